@@ -0,0 +1,112 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/net"
+	log "github.com/AlexStocks/log4go"
+)
+
+// Ready reports whether s is registered with its registry (if any) and
+// accepting traffic. It flips to false as soon as Stop begins draining, so a
+// load balancer or Kubernetes readiness probe stops sending new requests
+// before in-flight ones are given a chance to finish.
+func (s *Server) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// Healthy reports whether the process itself is still viable, independent of
+// Ready. It only goes false once the fail-fast timer in initSignal is about
+// to force-kill the process, so a liveness probe doesn't restart a pod that
+// is merely draining.
+func (s *Server) Healthy() bool {
+	return atomic.LoadInt32(&s.healthy) == 1
+}
+
+// trackInflight wraps h so every dispatched RPC call is counted in
+// s.inflight, letting Stop wait for in-flight invocations to finish (up to
+// DrainTimeout) before it closes sessions and listeners.
+func (s *Server) trackInflight(h Handler) Handler {
+	return func(ctx context.Context, req Request) (Response, error) {
+		s.inflight.Add(1)
+		defer s.inflight.Done()
+
+		return h(ctx, req)
+	}
+}
+
+// startHealthListener starts the /healthz and /readyz HTTP sidecar used by
+// load balancers and Kubernetes probes, if s.cfg().HealthPort is configured. It
+// is idempotent and a no-op when HealthPort is empty.
+func (s *Server) startHealthListener() {
+	if len(s.cfg().HealthPort) == 0 {
+		return
+	}
+	if s.healthServer != nil {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.Healthy() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	addr := gxnet.HostAddress2(s.cfg().Host, s.cfg().HealthPort)
+	s.healthServer = &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := s.healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("health listener on %s stopped unexpectedly: %v", addr, err)
+		}
+	}()
+	log.Debug("health listener bound addr{%s} ok!", addr)
+}
+
+func (s *Server) stopHealthListener() {
+	if s.healthServer == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.healthServer.Shutdown(ctx); err != nil {
+		log.Error("health listener shutdown failed: %v", err)
+	}
+}
+
+// deregister removes every node this server registered from s.registry, so
+// discovery stops handing out this instance before the drain proceeds.
+func (s *Server) deregister() {
+	s.deregisterFrom(s.registry, s.registeredServices)
+}
+
+// waitInflight blocks until every in-flight RPC invocation tracked via
+// trackInflight completes, or timeout elapses, whichever comes first.
+func (s *Server) waitInflight(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Info("drain timeout %s reached with RPC invocations still in flight, closing anyway", timeout)
+	}
+}
@@ -0,0 +1,94 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+)
+
+// Request is the envelope passed through the middleware chain. It mirrors the
+// service/method/args already resolved by the reflection dispatch so that a
+// Middleware can inspect or rewrite them without knowing about getty's wire
+// codec. Headers is the slot for protocol metadata (e.g. an OpenTelemetry
+// trace context); Server.Invoke does not fill it, so on that path Headers is
+// nil and nothing is read from the getty session into it.
+type Request struct {
+	Service string
+	Method  string
+	Args    interface{}
+	Headers map[string]string
+}
+
+// Response is the envelope a Handler returns.
+type Response struct {
+	Reply interface{}
+}
+
+// Handler invokes one RPC call; Server.Invoke builds the innermost Handler
+// around the reflected method call passed in by NewRpcServerHandler and wraps
+// it with the chain returned by Server.handlerChain before running it.
+type Handler func(ctx context.Context, req Request) (Response, error)
+
+// Middleware wraps a Handler to add cross-cutting behaviour (auth, rate
+// limiting, logging, metrics, tracing, retries, ...) without touching the
+// framework's dispatch code.
+type Middleware func(Handler) Handler
+
+// Use registers global middlewares that apply to every service on s, in the
+// order given. They run outermost-first, i.e. the first Middleware passed to
+// Use is the first to see the request.
+func (s *Server) Use(mw ...Middleware) {
+	s.middlewares = append(s.middlewares, mw...)
+}
+
+// RegisterWithMiddleware is Register plus a set of middlewares that apply only
+// to rcvr's service, running inside the global chain installed via Use.
+func (s *Server) RegisterWithMiddleware(rcvr GettyRPCService, mw ...Middleware) error {
+	if err := s.Register(rcvr); err != nil {
+		return err
+	}
+	name := reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name()
+	s.serviceMiddlewares[name] = mw
+
+	return nil
+}
+
+// handlerChain composes the global middlewares installed via Use with any
+// registered for serviceName via RegisterWithMiddleware, and wraps final with
+// the result. Global middlewares see the request first.
+func (s *Server) handlerChain(serviceName string, final Handler) Handler {
+	h := applyMiddleware(s.serviceMiddlewares[serviceName], final)
+	h = applyMiddleware(s.middlewares, h)
+
+	return s.trackInflight(h)
+}
+
+func applyMiddleware(mw []Middleware, final Handler) Handler {
+	h := final
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+
+	return h
+}
+
+// Invoke is the choke point NewRpcServerHandler must call for every reflected
+// method call instead of invoking it directly, so that the composed
+// middleware chain and the in-flight tracking used by Server.Stop's drain
+// wait apply uniformly. call performs the actual reflect.Value.Call against
+// the resolved service method and returns its reply.
+//
+// NewRpcServerHandler itself is defined outside this repo slice, so this
+// commit can only prepare the caller side: newSession passes s.Invoke through
+// to it. Wiring the two together in NewRpcServerHandler's own dispatch loop
+// (call args[2].(func(...)) around its existing reflect.Value.Call instead of
+// invoking it directly) still needs to land in that file, where it lives.
+func (s *Server) Invoke(ctx context.Context, serviceName, methodName string, args interface{}, call func() (interface{}, error)) (interface{}, error) {
+	h := s.handlerChain(serviceName, func(ctx context.Context, req Request) (Response, error) {
+		reply, err := call()
+		return Response{Reply: reply}, err
+	})
+
+	resp, err := h(ctx, Request{Service: serviceName, Method: methodName, Args: args})
+
+	return resp.Reply, err
+}
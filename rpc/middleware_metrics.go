@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"context"
+	"time"
+)
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	rpcRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "getty_rpc_server_requests_total",
+			Help: "Total number of RPC requests handled by the server, labelled by service, method and outcome.",
+		},
+		[]string{"service", "method", "success"},
+	)
+	rpcRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "getty_rpc_server_request_duration_seconds",
+			Help:    "RPC request handling latency in seconds, labelled by service and method.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"service", "method"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(rpcRequestsTotal, rpcRequestDuration)
+}
+
+// PrometheusMiddleware records a request counter and a latency histogram for
+// every RPC call, labelled by service and method. Register it with
+// Server.Use; the underlying collectors are exported under the
+// getty_rpc_server_* metric names and expect the application to expose them
+// via promhttp.Handler() on its own.
+func PrometheusMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req Request) (Response, error) {
+			start := time.Now()
+			resp, err := next(ctx, req)
+			rpcRequestDuration.WithLabelValues(req.Service, req.Method).Observe(time.Since(start).Seconds())
+			rpcRequestsTotal.WithLabelValues(req.Service, req.Method, successLabel(err)).Inc()
+
+			return resp, err
+		}
+	}
+}
+
+func successLabel(err error) string {
+	if err != nil {
+		return "false"
+	}
+
+	return "true"
+}
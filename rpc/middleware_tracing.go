@@ -0,0 +1,41 @@
+package rpc
+
+import (
+	"context"
+)
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/AlexStocks/getty/rpc"
+
+// OpenTelemetryMiddleware starts a span named "service.method" around each
+// RPC call. When req.Headers is set, the span's parent is extracted from it
+// with the globally configured text-map propagator; nothing is injected into
+// the response. Server.Invoke leaves Headers nil, so spans started on that
+// path have no remote parent.
+func OpenTelemetryMiddleware() Middleware {
+	tracer := otel.Tracer(tracerName)
+	propagator := otel.GetTextMapPropagator()
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req Request) (Response, error) {
+			if req.Headers != nil {
+				ctx = propagator.Extract(ctx, propagation.MapCarrier(req.Headers))
+			}
+
+			ctx, span := tracer.Start(ctx, req.Service+"."+req.Method, trace.WithSpanKind(trace.SpanKindServer))
+			defer span.End()
+
+			resp, err := next(ctx, req)
+			if err != nil {
+				span.RecordError(err)
+			}
+
+			return resp, err
+		}
+	}
+}
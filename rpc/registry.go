@@ -0,0 +1,79 @@
+package rpc
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/registry"
+	jerrors "github.com/juju/errors"
+)
+
+// RegistryFactory builds a gxregistry.Registry from the registry section of
+// a ServerConfig. Built-in registries register themselves via RegisterRegistry
+// from an init() function in their own file; out-of-tree registries can do the
+// same from application code before NewServer is called.
+type RegistryFactory func(conf *RegistryConfig) (gxregistry.Registry, error)
+
+var (
+	registryFactoriesMu sync.RWMutex
+	registryFactories   = make(map[string]RegistryFactory)
+)
+
+// RegisterRegistry makes a registry backend identified by @name available via
+// the "registry.type" config option. It panics if factory is nil or if a
+// backend is already registered under @name, analogous to database/sql.Register.
+func RegisterRegistry(name string, factory RegistryFactory) {
+	registryFactoriesMu.Lock()
+	defer registryFactoriesMu.Unlock()
+
+	if factory == nil {
+		panic("rpc: RegisterRegistry factory is nil")
+	}
+	if _, dup := registryFactories[name]; dup {
+		panic("rpc: RegisterRegistry called twice for registry type " + name)
+	}
+	registryFactories[name] = factory
+}
+
+// newRegistry is shared by NewServer/reload on the provider side. The
+// original request also asked to mirror this lookup on the client side; this
+// repo slice has no client-side RPC consumer (no client.go, nothing that
+// calls out to a provider) to wire it into, so that half of the request is
+// descoped here rather than shipped as an unused wrapper with no real call
+// site. Once a client package exists in this tree, it should call newRegistry
+// (or export it) the same way NewServer does.
+func newRegistry(conf *RegistryConfig) (gxregistry.Registry, error) {
+	registryFactoriesMu.RLock()
+	factory, ok := registryFactories[conf.Type]
+	registryFactoriesMu.RUnlock()
+	if !ok {
+		return nil, jerrors.New(fmt.Sprintf("rpc: unknown registry type %s, forgot to import its package?", conf.Type))
+	}
+
+	return factory(conf)
+}
+
+// buildNodes turns conf.Ports into the gxregistry.Node set advertised under
+// conf.Registry.NodeID, the same way for an initial NewServer and for a
+// config reload that changes the listening ports or the registry root.
+func buildNodes(conf *ServerConfig) ([]*gxregistry.Node, error) {
+	var nodes []*gxregistry.Node
+	for _, p := range conf.Ports {
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, jerrors.New(fmt.Sprintf("illegal port %s", p))
+		}
+
+		nodes = append(nodes, &gxregistry.Node{
+			ID:      conf.Registry.NodeID + "-" + net.JoinHostPort(conf.Host, p),
+			Address: conf.Host,
+			Port:    int32(port),
+		})
+	}
+
+	return nodes, nil
+}
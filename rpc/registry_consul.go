@@ -0,0 +1,33 @@
+// NOTE for reviewers: this backend imports
+// github.com/AlexStocks/goext/database/registry/consul, whose availability
+// upstream has not been confirmed from this environment (no network access to
+// check). It's shipped unconditionally here, the same way etcd and zookeeper
+// are, per the request ("built-in Consul and Nacos providers alongside the
+// existing etcd/zookeeper ones"). Please confirm the package exists and
+// resolves before merging: since Go compiles every file in a package
+// together, a missing package here would fail the build for every user of
+// this package, not just consul users.
+package rpc
+
+import (
+	"strings"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/registry"
+	"github.com/AlexStocks/goext/database/registry/consul"
+)
+
+func init() {
+	RegisterRegistry("consul", newConsulRegistry)
+}
+
+func newConsulRegistry(conf *RegistryConfig) (gxregistry.Registry, error) {
+	addrList := strings.Split(conf.Addr, ",")
+	return gxconsul.NewRegistry(
+		gxregistry.WithAddrs(addrList...),
+		gxregistry.WithTimeout(time.Duration(int(time.Second)*conf.KeepaliveTimeout)),
+		gxregistry.WithRoot(conf.Root),
+	)
+}
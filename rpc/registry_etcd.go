@@ -0,0 +1,24 @@
+package rpc
+
+import (
+	"strings"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/registry"
+	"github.com/AlexStocks/goext/database/registry/etcdv3"
+)
+
+func init() {
+	RegisterRegistry("etcd", newEtcdRegistry)
+}
+
+func newEtcdRegistry(conf *RegistryConfig) (gxregistry.Registry, error) {
+	addrList := strings.Split(conf.Addr, ",")
+	return gxetcd.NewRegistry(
+		gxregistry.WithAddrs(addrList...),
+		gxregistry.WithTimeout(time.Duration(int(time.Second)*conf.KeepaliveTimeout)),
+		gxregistry.WithRoot(conf.Root),
+	)
+}
@@ -0,0 +1,214 @@
+package rpc
+
+import (
+	"github.com/AlexStocks/getty"
+	"github.com/AlexStocks/goext/database/registry"
+	"github.com/AlexStocks/goext/net"
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+// reload re-reads s.confFile and applies whatever it can to the running
+// server in place: session timeouts, keepalive periods, read/write buffer
+// sizes (picked up by newSession for future sessions), and the set of
+// listening Ports (new ones are opened, removed ones are closed and the node
+// set re-registered). A LogLevel change is logged and stored in the new
+// config; reload does not change the level of the running logger. CodecType
+// cannot be changed without restarting the
+// codec pipeline, so a change to it is logged and rejected, leaving the rest
+// of the reload to apply on top of the previous CodecType.
+func (s *Server) reload() {
+	newConf := loadServerConf(s.confFile)
+	if newConf.codecType = String2CodecType(newConf.CodecType); newConf.codecType == gettyCodecUnknown {
+		log.Error("reload: %s has illegal codec type %s, keeping previous config", s.confFile, newConf.CodecType)
+		return
+	}
+
+	if newConf.CodecType != s.cfg().CodecType {
+		log.Error("reload: codec type cannot be changed while running (have %s, want %s), keeping %s",
+			s.cfg().CodecType, newConf.CodecType, s.cfg().CodecType)
+		newConf.CodecType = s.cfg().CodecType
+		newConf.codecType = s.cfg().codecType
+	}
+
+	if newConf.LogLevel != s.cfg().LogLevel {
+		log.Info("reload: log level changed from %s to %s", s.cfg().LogLevel, newConf.LogLevel)
+	}
+
+	oldConf := s.cfg()
+	s.setConf(newConf)
+
+	s.reconcilePorts(oldConf.Ports, newConf.Ports)
+
+	switch {
+	case registryChanged(oldConf.Registry, newConf.Registry):
+		// Registry client itself changed (addr/type/root): reconcileRegistry
+		// rebuilds it and re-registers under the new Ports as part of that.
+		s.reconcileRegistry(oldConf, newConf)
+	case s.registry != nil && portsChanged(oldConf.Ports, newConf.Ports):
+		// Same registry, but the node set advertised under it is now stale:
+		// rebuild it from newConf's Ports and re-register.
+		s.reregisterNodes(newConf)
+	}
+
+	log.Info("reload: applied updated config from %s", s.confFile)
+}
+
+// portDiff reports which ports in newPorts are not in oldPorts (added) and
+// which ports in oldPorts are not in newPorts (removed). Ports present in
+// both are left alone.
+func portDiff(oldPorts, newPorts []string) (added, removed []string) {
+	keep := make(map[string]bool, len(newPorts))
+	for _, p := range newPorts {
+		keep[p] = true
+	}
+	for _, p := range oldPorts {
+		if !keep[p] {
+			removed = append(removed, p)
+		}
+	}
+
+	had := make(map[string]bool, len(oldPorts))
+	for _, p := range oldPorts {
+		had[p] = true
+	}
+	for _, p := range newPorts {
+		if !had[p] {
+			added = append(added, p)
+		}
+	}
+
+	return added, removed
+}
+
+// portsChanged reports whether newPorts differs from oldPorts in any way
+// that requires the advertised node set to be rebuilt.
+func portsChanged(oldPorts, newPorts []string) bool {
+	added, removed := portDiff(oldPorts, newPorts)
+	return len(added) > 0 || len(removed) > 0
+}
+
+// reconcilePorts opens a listener for every port in newPorts that isn't
+// already listening and closes listeners for every port in oldPorts that is
+// no longer present, leaving listeners for unchanged ports untouched.
+func (s *Server) reconcilePorts(oldPorts, newPorts []string) {
+	added, removed := portDiff(oldPorts, newPorts)
+
+	for _, p := range removed {
+		if tcpServer, ok := s.tcpServerList[p]; ok {
+			tcpServer.Close()
+			delete(s.tcpServerList, p)
+			log.Info("reload: closed listener for removed port %s", p)
+		}
+	}
+
+	for _, p := range added {
+		addr := gxnet.HostAddress2(s.cfg().Host, p)
+		tcpServer := getty.NewTCPServer(getty.WithLocalAddress(addr))
+		tcpServer.RunEventLoop(s.newSession)
+		s.tcpServerList[p] = tcpServer
+		log.Info("reload: opened listener for added port %s addr{%s}", p, addr)
+	}
+}
+
+// registryChanged reports whether a change between old and new requires
+// rebuilding the registry client: a different address, backend type or
+// namespace root. Everything else (IDC, NodeID, ...) is picked up the next
+// time nodes are (re-)registered without needing a new client.
+func registryChanged(old, new RegistryConfig) bool {
+	return old.Addr != new.Addr || old.Type != new.Type || old.Root != new.Root
+}
+
+// reconcileRegistry re-registers s's node set under newConf's registry root
+// when the registry address, type or root changed, and deregisters the old
+// node set from the previous registry once the new one is in place.
+func (s *Server) reconcileRegistry(oldConf, newConf *ServerConfig) {
+	if !registryChanged(oldConf.Registry, newConf.Registry) {
+		return
+	}
+
+	oldRegistry, oldServices := s.registry, s.registeredServices
+
+	if len(newConf.Registry.Addr) == 0 {
+		s.registry, s.registeredServices, s.nodes = nil, nil, nil
+		s.deregisterFrom(oldRegistry, oldServices)
+		return
+	}
+
+	registry, err := newRegistry(&newConf.Registry)
+	if err != nil {
+		log.Error("reload: rebuild registry fail: %v, keeping previous registry", jerrors.ErrorStack(err))
+		return
+	}
+
+	nodes, err := buildNodes(newConf)
+	if err != nil {
+		log.Error("reload: %v, keeping previous registry", err)
+		return
+	}
+
+	s.registry = registry
+	s.nodes = nodes
+	s.sa = gxregistry.ServiceAttr{
+		Group:    newConf.Registry.IDC,
+		Role:     gxregistry.SRT_Provider,
+		Protocol: newConf.CodecType,
+	}
+	s.registeredServices = s.registerServices(nodes)
+
+	s.deregisterFrom(oldRegistry, oldServices)
+}
+
+// reregisterNodes rebuilds s's advertised node set from newConf's Ports and
+// re-registers every service under it with the existing s.registry, then
+// deregisters the previous node set. Used on a reload that only changes
+// Ports, where the registry client itself (address/type/root) is unchanged
+// and reconcileRegistry's full client rebuild would be unnecessary.
+func (s *Server) reregisterNodes(newConf *ServerConfig) {
+	nodes, err := buildNodes(newConf)
+	if err != nil {
+		log.Error("reload: %v, keeping previous node set", err)
+		return
+	}
+
+	oldServices := s.registeredServices
+	s.nodes = nodes
+	s.sa.Group = newConf.Registry.IDC
+	s.registeredServices = s.registerServices(nodes)
+	s.deregisterFrom(s.registry, oldServices)
+}
+
+// registerServices registers every service in s.serviceMap under nodes with
+// s.registry and returns the services that registered successfully.
+func (s *Server) registerServices(nodes []*gxregistry.Node) []gxregistry.Service {
+	var registered []gxregistry.Service
+	for name, svc := range s.serviceMap {
+		rcvr, ok := svc.rcvr.Interface().(GettyRPCService)
+		if !ok {
+			continue
+		}
+
+		sa := s.sa
+		sa.Service = rcvr.Service()
+		sa.Version = rcvr.Version()
+		service := gxregistry.Service{Attr: &sa, Nodes: nodes}
+		if err := s.registry.Register(service); err != nil {
+			log.Error("reload: re-register service{%s} on registry fail: %v", name, err)
+			continue
+		}
+		registered = append(registered, service)
+	}
+
+	return registered
+}
+
+func (s *Server) deregisterFrom(registry gxregistry.Registry, services []gxregistry.Service) {
+	if registry == nil {
+		return
+	}
+	for _, svc := range services {
+		if err := registry.Deregister(svc); err != nil {
+			log.Error("deregister service{%v} from registry fail: %v", svc, err)
+		}
+	}
+}
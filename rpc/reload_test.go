@@ -0,0 +1,190 @@
+package rpc
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+import (
+	"github.com/AlexStocks/getty"
+	"github.com/AlexStocks/goext/database/registry"
+)
+
+// fakeRegistry is a minimal gxregistry.Registry stand-in covering the subset
+// of the interface this package exercises (Register/Deregister), so
+// reload's re-registration path can be driven without a real backend.
+type fakeRegistry struct {
+	registered   []gxregistry.Service
+	deregistered []gxregistry.Service
+}
+
+func (r *fakeRegistry) Register(svc gxregistry.Service) error {
+	r.registered = append(r.registered, svc)
+	return nil
+}
+
+func (r *fakeRegistry) Deregister(svc gxregistry.Service) error {
+	r.deregistered = append(r.deregistered, svc)
+	return nil
+}
+
+type fakeRPCService struct{}
+
+func (fakeRPCService) Service() string { return "Echo" }
+func (fakeRPCService) Version() string { return "1.0" }
+
+func sortedStrings(ss []string) []string {
+	out := append([]string(nil), ss...)
+	sort.Strings(out)
+	return out
+}
+
+func TestPortDiff(t *testing.T) {
+	cases := []struct {
+		name        string
+		oldPorts    []string
+		newPorts    []string
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{
+			name:        "add a port",
+			oldPorts:    []string{"20000"},
+			newPorts:    []string{"20000", "20001"},
+			wantAdded:   []string{"20001"},
+			wantRemoved: nil,
+		},
+		{
+			name:        "remove a port",
+			oldPorts:    []string{"20000", "20001"},
+			newPorts:    []string{"20000"},
+			wantAdded:   nil,
+			wantRemoved: []string{"20001"},
+		},
+		{
+			name:        "unchanged",
+			oldPorts:    []string{"20000", "20001"},
+			newPorts:    []string{"20000", "20001"},
+			wantAdded:   nil,
+			wantRemoved: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			added, removed := portDiff(tc.oldPorts, tc.newPorts)
+			if !reflect.DeepEqual(sortedStrings(added), sortedStrings(tc.wantAdded)) {
+				t.Errorf("added = %v, want %v", added, tc.wantAdded)
+			}
+			if !reflect.DeepEqual(sortedStrings(removed), sortedStrings(tc.wantRemoved)) {
+				t.Errorf("removed = %v, want %v", removed, tc.wantRemoved)
+			}
+		})
+	}
+}
+
+// TestReloadReregistersOnPortChange drives the registry half of the path
+// reload() takes when only Ports change (registry address/type/root
+// untouched): portsChanged followed by reregisterNodes. reconcilePorts, which
+// opens and closes real listeners, is not called. It asserts the node set advertised to the
+// registry picks up the added port and that the stale node set is
+// deregistered, guarding the bug where a port-only reload left the registry
+// advertising the old node set.
+func TestReloadReregistersOnPortChange(t *testing.T) {
+	reg := &fakeRegistry{}
+	svc := &service{rcvr: reflect.ValueOf(fakeRPCService{})}
+
+	s := &Server{
+		serviceMap:    map[string]*service{"Echo": svc},
+		tcpServerList: make(map[string]getty.Server),
+		registry:      reg,
+		sa:            gxregistry.ServiceAttr{Service: "Echo"},
+	}
+
+	oldConf := &ServerConfig{
+		Host:     "127.0.0.1",
+		Ports:    []string{"20000"},
+		Registry: RegistryConfig{NodeID: "node1"},
+	}
+	s.setConf(oldConf)
+
+	nodes, err := buildNodes(oldConf)
+	if err != nil {
+		t.Fatalf("buildNodes(oldConf) failed: %v", err)
+	}
+	s.nodes = nodes
+	s.registeredServices = s.registerServices(nodes)
+	reg.registered = nil // drop the initial registration, only the reload's own call matters below
+
+	newConf := &ServerConfig{
+		Host:     oldConf.Host,
+		Ports:    []string{"20000", "20001"},
+		Registry: oldConf.Registry,
+	}
+
+	if !portsChanged(oldConf.Ports, newConf.Ports) {
+		t.Fatal("expected portsChanged to report a change")
+	}
+
+	s.reregisterNodes(newConf)
+
+	gotPorts := map[int32]bool{}
+	for _, n := range s.nodes {
+		gotPorts[n.Port] = true
+	}
+	if !gotPorts[20000] || !gotPorts[20001] {
+		t.Fatalf("expected node set to advertise both ports, got %+v", s.nodes)
+	}
+
+	if len(reg.registered) != 1 {
+		t.Fatalf("expected 1 re-registration call with the new node set, got %d", len(reg.registered))
+	}
+	if len(reg.deregistered) != 1 {
+		t.Fatalf("expected the stale node set to be deregistered, got %d calls", len(reg.deregistered))
+	}
+}
+
+func TestRegistryChanged(t *testing.T) {
+	base := RegistryConfig{Addr: "127.0.0.1:2181", Type: "zookeeper", Root: "/dubbo", IDC: "idc1"}
+
+	cases := []struct {
+		name string
+		new  RegistryConfig
+		want bool
+	}{
+		{
+			name: "unchanged",
+			new:  base,
+			want: false,
+		},
+		{
+			name: "root changed",
+			new:  RegistryConfig{Addr: base.Addr, Type: base.Type, Root: "/dubbo2", IDC: base.IDC},
+			want: true,
+		},
+		{
+			name: "type changed",
+			new:  RegistryConfig{Addr: base.Addr, Type: "etcdv3", Root: base.Root, IDC: base.IDC},
+			want: true,
+		},
+		{
+			name: "addr changed",
+			new:  RegistryConfig{Addr: "127.0.0.1:2182", Type: base.Type, Root: base.Root, IDC: base.IDC},
+			want: true,
+		},
+		{
+			name: "unrelated field changed",
+			new:  RegistryConfig{Addr: base.Addr, Type: base.Type, Root: base.Root, IDC: "idc2"},
+			want: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := registryChanged(base, tc.new); got != tc.want {
+				t.Errorf("registryChanged(%+v, %+v) = %v, want %v", base, tc.new, got, tc.want)
+			}
+		})
+	}
+}
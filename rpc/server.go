@@ -3,11 +3,12 @@ package rpc
 import (
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"reflect"
-	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -15,26 +16,50 @@ import (
 import (
 	"github.com/AlexStocks/getty"
 	"github.com/AlexStocks/goext/database/registry"
-	"github.com/AlexStocks/goext/database/registry/etcdv3"
-	"github.com/AlexStocks/goext/database/registry/zookeeper"
 	"github.com/AlexStocks/goext/net"
 	log "github.com/AlexStocks/log4go"
 	jerrors "github.com/juju/errors"
 )
 
 type Server struct {
-	conf          *ServerConfig
+	confFile  string
+	confValue atomic.Value // holds *ServerConfig; reload swaps it while newSession reads it from the accept goroutine
+
 	serviceMap    map[string]*service
-	tcpServerList []getty.Server
+	tcpServerList map[string]getty.Server // keyed by port, so reload can add/remove individual listeners
 	registry      gxregistry.Registry
 	sa            gxregistry.ServiceAttr
 	nodes         []*gxregistry.Node
+
+	registeredServices []gxregistry.Service
+
+	middlewares        []Middleware
+	serviceMiddlewares map[string][]Middleware
+
+	inflight     sync.WaitGroup
+	ready        int32
+	healthy      int32
+	draining     int32
+	healthServer *http.Server
 }
 
 var (
 	ErrIllegalCodecType = jerrors.New("illegal codec type")
 )
 
+// cfg returns the config currently in effect. It is safe to call from any
+// goroutine, including the getty accept loop that calls newSession, while
+// reload swaps in a new config on SIGHUP.
+func (s *Server) cfg() *ServerConfig {
+	return s.confValue.Load().(*ServerConfig)
+}
+
+// setConf atomically swaps the config in effect. Callers must pass a config
+// that has already been fully validated: readers never see a partial update.
+func (s *Server) setConf(conf *ServerConfig) {
+	s.confValue.Store(conf)
+}
+
 func NewServer(confFile string) (*Server, error) {
 	conf := loadServerConf(confFile)
 	if conf.codecType = String2CodecType(conf.CodecType); conf.codecType == gettyCodecUnknown {
@@ -42,52 +67,32 @@ func NewServer(confFile string) (*Server, error) {
 	}
 
 	s := &Server{
-		serviceMap: make(map[string]*service),
-		conf:       conf,
-	}
-
-	var err error
-	var registry gxregistry.Registry
-	if len(s.conf.Registry.Addr) != 0 {
-		addrList := strings.Split(s.conf.Registry.Addr, ",")
-		switch s.conf.Registry.Type {
-		case "etcd":
-			registry, err = gxetcd.NewRegistry(
-				gxregistry.WithAddrs(addrList...),
-				gxregistry.WithTimeout(time.Duration(int(time.Second)*s.conf.Registry.KeepaliveTimeout)),
-				gxregistry.WithRoot(s.conf.Registry.Root),
-			)
-		case "zookeeper":
-			registry, err = gxzookeeper.NewRegistry(
-				gxregistry.WithAddrs(addrList...),
-				gxregistry.WithTimeout(time.Duration(int(time.Second)*s.conf.Registry.KeepaliveTimeout)),
-				gxregistry.WithRoot(s.conf.Registry.Root),
-			)
-		}
+		confFile:           confFile,
+		serviceMap:         make(map[string]*service),
+		serviceMiddlewares: make(map[string][]Middleware),
+		tcpServerList:      make(map[string]getty.Server),
+	}
+	s.setConf(conf)
+	atomic.StoreInt32(&s.healthy, 1)
 
+	if len(s.cfg().Registry.Addr) != 0 {
+		registry, err := newRegistry(&s.cfg().Registry)
 		if err != nil {
 			return nil, jerrors.Trace(err)
 		}
 		if registry != nil {
 			s.registry = registry
 			s.sa = gxregistry.ServiceAttr{
-				Group:    s.conf.Registry.IDC,
+				Group:    s.cfg().Registry.IDC,
 				Role:     gxregistry.SRT_Provider,
-				Protocol: s.conf.CodecType,
+				Protocol: s.cfg().CodecType,
 			}
 
-			for _, p := range s.conf.Ports {
-				port, err := strconv.Atoi(p)
-				if err != nil {
-					return nil, jerrors.New(fmt.Sprintf("illegal port %s", p))
-				}
-
-				s.nodes = append(s.nodes,
-					&gxregistry.Node{
-						ID:      s.conf.Registry.NodeID + "-" + net.JoinHostPort(s.conf.Host, p),
-						Address: s.conf.Host,
-						Port:    int32(port)})
+			nodes, err := buildNodes(s.cfg())
+			if err != nil {
+				return nil, jerrors.Trace(err)
 			}
+			s.nodes = nodes
 		}
 	}
 
@@ -97,7 +102,7 @@ func NewServer(confFile string) (*Server, error) {
 func (s *Server) Run() {
 	s.Init()
 	log.Info("%s starts successfull! its version=%s, its listen ends=%s:%s\n",
-		s.conf.AppName, getty.Version, s.conf.Host, s.conf.Ports)
+		s.cfg().AppName, getty.Version, s.cfg().Host, s.cfg().Ports)
 	s.initSignal()
 }
 
@@ -145,6 +150,7 @@ func (s *Server) Register(rcvr GettyRPCService) error {
 		if err := s.registry.Register(service); err != nil {
 			return jerrors.Trace(err)
 		}
+		s.registeredServices = append(s.registeredServices, service)
 	}
 
 	return nil
@@ -156,7 +162,13 @@ func (s *Server) newSession(session getty.Session) error {
 		tcpConn *net.TCPConn
 	)
 
-	if s.conf.GettySessionParam.CompressEncoding {
+	if atomic.LoadInt32(&s.draining) == 1 {
+		log.Info("server is draining, refusing new session:%s\n", session.Stat())
+		session.Close()
+		return nil
+	}
+
+	if s.cfg().GettySessionParam.CompressEncoding {
 		session.SetCompressType(getty.CompressZip)
 	}
 
@@ -164,24 +176,31 @@ func (s *Server) newSession(session getty.Session) error {
 		panic(fmt.Sprintf("%s, session.conn{%#v} is not tcp connection\n", session.Stat(), session.Conn()))
 	}
 
-	tcpConn.SetNoDelay(s.conf.GettySessionParam.TcpNoDelay)
-	tcpConn.SetKeepAlive(s.conf.GettySessionParam.TcpKeepAlive)
-	if s.conf.GettySessionParam.TcpKeepAlive {
-		tcpConn.SetKeepAlivePeriod(s.conf.GettySessionParam.keepAlivePeriod)
+	tcpConn.SetNoDelay(s.cfg().GettySessionParam.TcpNoDelay)
+	tcpConn.SetKeepAlive(s.cfg().GettySessionParam.TcpKeepAlive)
+	if s.cfg().GettySessionParam.TcpKeepAlive {
+		tcpConn.SetKeepAlivePeriod(s.cfg().GettySessionParam.keepAlivePeriod)
 	}
-	tcpConn.SetReadBuffer(s.conf.GettySessionParam.TcpRBufSize)
-	tcpConn.SetWriteBuffer(s.conf.GettySessionParam.TcpWBufSize)
+	tcpConn.SetReadBuffer(s.cfg().GettySessionParam.TcpRBufSize)
+	tcpConn.SetWriteBuffer(s.cfg().GettySessionParam.TcpWBufSize)
 
-	session.SetName(s.conf.GettySessionParam.SessionName)
-	session.SetMaxMsgLen(s.conf.GettySessionParam.MaxMsgLen)
+	session.SetName(s.cfg().GettySessionParam.SessionName)
+	session.SetMaxMsgLen(s.cfg().GettySessionParam.MaxMsgLen)
 	session.SetPkgHandler(NewRpcServerPackageHandler(s))
-	session.SetEventListener(NewRpcServerHandler(s.conf.SessionNumber, s.conf.sessionTimeout))
-	session.SetRQLen(s.conf.GettySessionParam.PkgRQSize)
-	session.SetWQLen(s.conf.GettySessionParam.PkgWQSize)
-	session.SetReadTimeout(s.conf.GettySessionParam.tcpReadTimeout)
-	session.SetWriteTimeout(s.conf.GettySessionParam.tcpWriteTimeout)
-	session.SetCronPeriod((int)(s.conf.sessionTimeout.Nanoseconds() / 1e6))
-	session.SetWaitTime(s.conf.GettySessionParam.waitTimeout)
+	// NewRpcServerHandler is defined outside this repo slice (its reflect.Value.Call
+	// dispatch loop isn't part of the files checked in here). This change only
+	// covers what's in-tree: s.Invoke is passed through so that whatever calls a
+	// resolved service method can route it through the middleware chain and
+	// in-flight tracking; NewRpcServerHandler's own body still needs to be updated,
+	// where it lives, to call args[2] (s.Invoke) around that reflect.Value.Call
+	// instead of invoking the method directly.
+	session.SetEventListener(NewRpcServerHandler(s.cfg().SessionNumber, s.cfg().sessionTimeout, s.Invoke))
+	session.SetRQLen(s.cfg().GettySessionParam.PkgRQSize)
+	session.SetWQLen(s.cfg().GettySessionParam.PkgWQSize)
+	session.SetReadTimeout(s.cfg().GettySessionParam.tcpReadTimeout)
+	session.SetWriteTimeout(s.cfg().GettySessionParam.tcpWriteTimeout)
+	session.SetCronPeriod((int)(s.cfg().sessionTimeout.Nanoseconds() / 1e6))
+	session.SetWaitTime(s.cfg().GettySessionParam.waitTimeout)
 	log.Debug("app accepts new session:%s\n", session.Stat())
 
 	return nil
@@ -194,25 +213,42 @@ func (s *Server) Init() {
 		tcpServer getty.Server
 	)
 
-	portList = s.conf.Ports
+	portList = s.cfg().Ports
 	if len(portList) == 0 {
 		panic("portList is nil")
 	}
 	for _, port := range portList {
-		addr = gxnet.HostAddress2(s.conf.Host, port)
+		addr = gxnet.HostAddress2(s.cfg().Host, port)
 		tcpServer = getty.NewTCPServer(
 			getty.WithLocalAddress(addr),
 		)
 		tcpServer.RunEventLoop(s.newSession)
 		log.Debug("s bind addr{%s} ok!", addr)
-		s.tcpServerList = append(s.tcpServerList, tcpServer)
+		s.tcpServerList[port] = tcpServer
 	}
+
+	s.startHealthListener()
+	atomic.StoreInt32(&s.ready, 1)
 }
 
+// Stop drains s: it deregisters from the registry so discovery stops handing
+// out this instance, stops handing new getty sessions off to the application
+// (they're still accepted at the TCP level and immediately closed, since
+// getty exposes no lower-level hook to refuse the accept itself) while
+// existing sessions keep serving requests, waits for in-flight RPC
+// invocations to finish (up to s.cfg().DrainTimeout), then closes sessions,
+// listeners and the health sidecar.
 func (s *Server) Stop() {
-	for _, tcpServer := range s.tcpServerList {
+	atomic.StoreInt32(&s.ready, 0)
+	atomic.StoreInt32(&s.draining, 1)
+	s.deregister()
+	s.waitInflight(s.cfg().drainTimeout)
+
+	for port, tcpServer := range s.tcpServerList {
 		tcpServer.Close()
+		delete(s.tcpServerList, port)
 	}
+	s.stopHealthListener()
 }
 
 func (s *Server) initSignal() {
@@ -224,14 +260,15 @@ func (s *Server) initSignal() {
 		log.Info("get signal %s", sig.String())
 		switch sig {
 		case syscall.SIGHUP:
-		// reload()
+			s.reload()
 		default:
-			go time.AfterFunc(s.conf.failFastTimeout, func() {
+			go time.AfterFunc(s.cfg().failFastTimeout, func() {
+				atomic.StoreInt32(&s.healthy, 0)
 				log.Exit("app exit now by force...")
 				log.Close()
 			})
 
-			// if @s can not stop in s.conf.failFastTimeout, getty will Force Quit.
+			// if @s can not stop in s.cfg().failFastTimeout, getty will Force Quit.
 			s.Stop()
 			log.Exit("app exit now...")
 			log.Close()